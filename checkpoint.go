@@ -0,0 +1,156 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Checkpoint is the full state a search needs to resume later: the
+// frontier still to explore, the results found so far, the per-branch
+// exhausted-char sets (so a branch interrupted mid-flight doesn't
+// re-probe characters it already ruled out), and a hash of the oracle
+// configuration so we refuse to resume against a mismatched target.
+type Checkpoint struct {
+	Pending    map[string]string   `json:"pending"`
+	Res        map[string]bool     `json:"res"`
+	Exhausted  map[string][]string `json:"exhausted"`
+	OracleHash string              `json:"oracle_hash"`
+}
+
+// Store persists and re-hydrates a Checkpoint.
+type Store interface {
+	Save(Checkpoint) error
+	Load() (Checkpoint, error)
+}
+
+// FileStore is a JSON file-backed Store.
+type FileStore struct {
+	Path string
+}
+
+// Save implements Store.
+func (s *FileStore) Save(cp Checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, data, 0o600)
+}
+
+// Load implements Store.
+func (s *FileStore) Load() (Checkpoint, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return Checkpoint{}, err
+	}
+	return cp, nil
+}
+
+// MemStore is an in-memory Store, handy for tests and for library callers
+// that checkpoint to their own storage.
+type MemStore struct {
+	mu  sync.Mutex
+	cp  Checkpoint
+	has bool
+}
+
+// Save implements Store.
+func (s *MemStore) Save(cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cp = cp
+	s.has = true
+	return nil
+}
+
+// Load implements Store.
+func (s *MemStore) Load() (Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.has {
+		return Checkpoint{}, errors.New("checkpoint: nothing saved yet")
+	}
+	return s.cp, nil
+}
+
+// HashOracleConfig fingerprints an oracleConfig so a Checkpoint can be
+// tied to the target it was taken against.
+func HashOracleConfig(c oracleConfig) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%+v", c)))
+	return hex.EncodeToString(h[:])
+}
+
+// MergeCheckpoints unions several checkpoints taken against the same
+// target (same OracleHash) into one, for recombining shards of a search
+// that was split across machines with -only-prefix. Res and Pending are
+// merged by key; Exhausted lists are concatenated per branch.
+func MergeCheckpoints(cps ...Checkpoint) (Checkpoint, error) {
+	merged := Checkpoint{
+		Pending:   map[string]string{},
+		Res:       map[string]bool{},
+		Exhausted: map[string][]string{},
+	}
+
+	for _, cp := range cps {
+		if merged.OracleHash == "" {
+			merged.OracleHash = cp.OracleHash
+		} else if cp.OracleHash != "" && cp.OracleHash != merged.OracleHash {
+			return Checkpoint{}, errors.New("checkpoint: cannot merge checkpoints from different oracle configurations")
+		}
+
+		for k, v := range cp.Pending {
+			merged.Pending[k] = v
+		}
+		for k, v := range cp.Res {
+			merged.Res[k] = v
+		}
+		for k, v := range cp.Exhausted {
+			merged.Exhausted[k] = append(merged.Exhausted[k], v...)
+		}
+	}
+
+	return merged, nil
+}
+
+// mergeCheckpointFiles is the -merge CLI entry point: it loads each of
+// paths as a checkpoint file, unions them with MergeCheckpoints, and
+// writes the result to out, for recombining the shards of a search split
+// across machines with -max-depth/-only-prefix.
+func mergeCheckpointFiles(paths []string, out string) error {
+	if len(paths) == 0 {
+		return errors.New("checkpoint: -merge needs at least one checkpoint file")
+	}
+	if out == "" {
+		return errors.New("checkpoint: -merge needs -checkpoint to say where to write the result")
+	}
+
+	cps := make([]Checkpoint, 0, len(paths))
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		cp, err := (&FileStore{Path: p}).Load()
+		if err != nil {
+			return fmt.Errorf("checkpoint: loading %s: %w", p, err)
+		}
+		cps = append(cps, cp)
+	}
+
+	merged, err := MergeCheckpoints(cps...)
+	if err != nil {
+		return err
+	}
+	return (&FileStore{Path: out}).Save(merged)
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMemStoreSaveLoad(t *testing.T) {
+	var s MemStore
+
+	if _, err := s.Load(); err == nil {
+		t.Fatal("Load on empty MemStore: want error, got nil")
+	}
+
+	cp := Checkpoint{Pending: map[string]string{"ab": "->"}, OracleHash: "deadbeef"}
+	if err := s.Save(cp); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.Pending["ab"] != "->" || got.OracleHash != "deadbeef" {
+		t.Errorf("Load() = %+v, want %+v", got, cp)
+	}
+}
+
+func TestHashOracleConfigStableAndDistinct(t *testing.T) {
+	a := oracleConfig{kind: "exec", cmd: "sh a.sh"}
+	b := oracleConfig{kind: "exec", cmd: "sh b.sh"}
+
+	if HashOracleConfig(a) != HashOracleConfig(a) {
+		t.Error("HashOracleConfig is not stable for the same config")
+	}
+	if HashOracleConfig(a) == HashOracleConfig(b) {
+		t.Error("HashOracleConfig collided for different configs")
+	}
+}
+
+func TestMergeCheckpoints(t *testing.T) {
+	a := Checkpoint{
+		Pending:    map[string]string{"ab": "->"},
+		Res:        map[string]bool{"abc": true},
+		Exhausted:  map[string][]string{"ab\x00->": {"x"}},
+		OracleHash: "same",
+	}
+	b := Checkpoint{
+		Pending:    map[string]string{"cd": "<-"},
+		Res:        map[string]bool{"cde": true},
+		Exhausted:  map[string][]string{"ab\x00->": {"y"}},
+		OracleHash: "same",
+	}
+
+	merged, err := MergeCheckpoints(a, b)
+	if err != nil {
+		t.Fatalf("MergeCheckpoints: %v", err)
+	}
+	if merged.Pending["ab"] != "->" || merged.Pending["cd"] != "<-" {
+		t.Errorf("Pending not unioned: %+v", merged.Pending)
+	}
+	if !merged.Res["abc"] || !merged.Res["cde"] {
+		t.Errorf("Res not unioned: %+v", merged.Res)
+	}
+	if len(merged.Exhausted["ab\x00->"]) != 2 {
+		t.Errorf("Exhausted lists not concatenated: %+v", merged.Exhausted["ab\x00->"])
+	}
+	if merged.OracleHash != "same" {
+		t.Errorf("OracleHash = %q, want %q", merged.OracleHash, "same")
+	}
+}
+
+func TestMergeCheckpointsRejectsMismatchedOracle(t *testing.T) {
+	a := Checkpoint{OracleHash: "one"}
+	b := Checkpoint{OracleHash: "two"}
+	if _, err := MergeCheckpoints(a, b); err == nil {
+		t.Fatal("MergeCheckpoints with mismatched OracleHash: want error, got nil")
+	}
+}
+
+func TestMergeCheckpointFiles(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.json")
+	bPath := filepath.Join(dir, "b.json")
+	outPath := filepath.Join(dir, "merged.json")
+
+	a := Checkpoint{Pending: map[string]string{"ab": "->"}, Res: map[string]bool{}, Exhausted: map[string][]string{}, OracleHash: "same"}
+	b := Checkpoint{Pending: map[string]string{"cd": "<-"}, Res: map[string]bool{}, Exhausted: map[string][]string{}, OracleHash: "same"}
+	if err := (&FileStore{Path: aPath}).Save(a); err != nil {
+		t.Fatalf("Save a: %v", err)
+	}
+	if err := (&FileStore{Path: bPath}).Save(b); err != nil {
+		t.Fatalf("Save b: %v", err)
+	}
+
+	if err := mergeCheckpointFiles([]string{aPath, bPath}, outPath); err != nil {
+		t.Fatalf("mergeCheckpointFiles: %v", err)
+	}
+
+	merged, err := (&FileStore{Path: outPath}).Load()
+	if err != nil {
+		t.Fatalf("Load merged: %v", err)
+	}
+	if merged.Pending["ab"] != "->" || merged.Pending["cd"] != "<-" {
+		t.Errorf("merged.Pending = %+v, want both ab and cd", merged.Pending)
+	}
+}
+
+func TestMergeCheckpointFilesRequiresOutPath(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.json")
+	if err := (&FileStore{Path: aPath}).Save(Checkpoint{}); err != nil {
+		t.Fatalf("Save a: %v", err)
+	}
+	if err := mergeCheckpointFiles([]string{aPath}, ""); err == nil {
+		t.Fatal("mergeCheckpointFiles with no output path: want error, got nil")
+	}
+}
+
+func TestMergeCheckpointFilesRequiresInputs(t *testing.T) {
+	if err := mergeCheckpointFiles(nil, "/tmp/out.json"); err == nil {
+		t.Fatal("mergeCheckpointFiles with no input files: want error, got nil")
+	}
+}
+
+func TestMergeCheckpointFilesTrimsSpace(t *testing.T) {
+	dir := t.TempDir()
+	aPath := filepath.Join(dir, "a.json")
+	outPath := filepath.Join(dir, "merged.json")
+
+	a := Checkpoint{Pending: map[string]string{"ab": "->"}, Res: map[string]bool{}, Exhausted: map[string][]string{}, OracleHash: "same"}
+	if err := (&FileStore{Path: aPath}).Save(a); err != nil {
+		t.Fatalf("Save a: %v", err)
+	}
+
+	// strings.Split on a user-typed "a.json, b.json" leaves a leading
+	// space on later entries; mergeCheckpointFiles must tolerate it.
+	if err := mergeCheckpointFiles([]string{" " + aPath + " "}, outPath); err != nil {
+		t.Fatalf("mergeCheckpointFiles with padded path: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of progress event a Bus carries.
+type EventType string
+
+const (
+	ProbeIssued       EventType = "ProbeIssued"
+	ProbeScored       EventType = "ProbeScored"
+	CandidateExtended EventType = "CandidateExtended"
+	BranchExhausted   EventType = "BranchExhausted"
+	ResultFound       EventType = "ResultFound"
+	StabilityWarning  EventType = "StabilityWarning"
+)
+
+// Event describes a single step of the search, published on a Bus so that
+// both the CLI and library callers (a TUI, a web dashboard, a fuzzer) can
+// observe progress without guessIt knowing how it is rendered.
+type Event struct {
+	Type           EventType
+	Time           time.Time
+	Term           string
+	Direction      string
+	Score          int
+	Classification Classification
+	Message        string
+}
+
+// Bus is a minimal subscribe/publish hub: guessIt publishes Events to it,
+// and any number of subscribers can register a filtered channel.
+type Bus struct {
+	mu   sync.Mutex
+	subs []*subscription
+}
+
+type subscription struct {
+	ch     chan Event
+	filter *eventFilter
+}
+
+// NewBus returns an empty, ready to use Bus.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Publish sends e to every subscriber whose filter matches it. Slow
+// subscribers never block the search: an event is dropped for a
+// subscriber whose channel is full.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new listener and returns the channel it will
+// receive matching events on. filter is a small query language, e.g.
+// "type='ProbeScored' AND score>10"; an empty filter matches everything.
+// The channel is closed once ctx is done.
+func (b *Bus) Subscribe(ctx context.Context, filter string) (<-chan Event, error) {
+	f, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &subscription{ch: make(chan Event, 64), filter: f}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s == sub {
+				b.subs = append(b.subs[:i], b.subs[i+1:]...)
+				break
+			}
+		}
+		close(sub.ch)
+	}()
+
+	return sub.ch, nil
+}
+
+// eventFilter is a small conjunction of clauses, e.g. the query
+// "type='ProbeScored' AND score>10" parses into two clauses ANDed
+// together.
+type eventFilter struct {
+	clauses []filterClause
+}
+
+type filterClause struct {
+	field   string
+	op      string
+	strVal  string
+	numVal  float64
+	numeric bool
+}
+
+var clauseRe = regexp.MustCompile(`^(\w+)\s*(=|!=|>=|<=|>|<)\s*(.+)$`)
+var andSplitRe = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+func parseFilter(query string) (*eventFilter, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return &eventFilter{}, nil
+	}
+
+	var clauses []filterClause
+	for _, part := range andSplitRe.Split(query, -1) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m := clauseRe.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("events: invalid filter clause %q", part)
+		}
+
+		c := filterClause{field: strings.ToLower(m[1]), op: m[2]}
+		raw := strings.TrimSpace(m[3])
+		if len(raw) >= 2 && (raw[0] == '\'' || raw[0] == '"') && raw[len(raw)-1] == raw[0] {
+			c.strVal = raw[1 : len(raw)-1]
+		} else if n, err := strconv.ParseFloat(raw, 64); err == nil {
+			c.numVal = n
+			c.numeric = true
+		} else {
+			c.strVal = raw
+		}
+		clauses = append(clauses, c)
+	}
+
+	return &eventFilter{clauses: clauses}, nil
+}
+
+// Match reports whether every clause in f holds for e. A nil or empty
+// filter matches everything.
+func (f *eventFilter) Match(e Event) bool {
+	if f == nil {
+		return true
+	}
+	for _, c := range f.clauses {
+		if !c.match(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c filterClause) match(e Event) bool {
+	strVal, numVal, numeric := eventField(e, c.field)
+	if c.numeric || numeric {
+		switch c.op {
+		case "=":
+			return numVal == c.numVal
+		case "!=":
+			return numVal != c.numVal
+		case ">":
+			return numVal > c.numVal
+		case "<":
+			return numVal < c.numVal
+		case ">=":
+			return numVal >= c.numVal
+		case "<=":
+			return numVal <= c.numVal
+		}
+		return false
+	}
+
+	switch c.op {
+	case "=":
+		return strVal == c.strVal
+	case "!=":
+		return strVal != c.strVal
+	default:
+		return false
+	}
+}
+
+func eventField(e Event, field string) (strVal string, numVal float64, numeric bool) {
+	switch field {
+	case "type":
+		return string(e.Type), 0, false
+	case "term":
+		return e.Term, 0, false
+	case "direction":
+		return e.Direction, 0, false
+	case "message":
+		return e.Message, 0, false
+	case "classification":
+		return e.Classification.String(), 0, false
+	case "score":
+		return "", float64(e.Score), true
+	default:
+		return "", 0, false
+	}
+}
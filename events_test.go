@@ -0,0 +1,160 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseFilterAndMatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter string
+		event  Event
+		want   bool
+	}{
+		{"empty filter matches everything", "", Event{Type: ProbeScored}, true},
+		{"simple string equality", "type='ProbeScored'", Event{Type: ProbeScored}, true},
+		{"string inequality", "type!='ProbeScored'", Event{Type: ProbeIssued}, true},
+		{"numeric comparison", "score>10", Event{Score: 11}, true},
+		{"numeric comparison false", "score>10", Event{Score: 10}, false},
+		{"AND conjunction", "type='ProbeScored' AND score>10", Event{Type: ProbeScored, Score: 11}, true},
+		{"AND conjunction short-circuits", "type='ProbeScored' AND score>10", Event{Type: ProbeScored, Score: 5}, false},
+	}
+	for _, c := range cases {
+		f, err := parseFilter(c.filter)
+		if err != nil {
+			t.Fatalf("%s: parseFilter: %v", c.name, err)
+		}
+		if got := f.Match(c.event); got != c.want {
+			t.Errorf("%s: Match() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	if _, err := parseFilter("not a valid clause"); err == nil {
+		t.Fatal("parseFilter with malformed clause: want error, got nil")
+	}
+}
+
+func TestEventField(t *testing.T) {
+	e := Event{Type: ProbeScored, Term: "ab", Direction: "->", Score: 7, Classification: Right, Message: "msg"}
+
+	cases := []struct {
+		field     string
+		wantStr   string
+		wantNum   float64
+		wantIsNum bool
+	}{
+		{"type", "ProbeScored", 0, false},
+		{"term", "ab", 0, false},
+		{"direction", "->", 0, false},
+		{"message", "msg", 0, false},
+		{"classification", "Right", 0, false},
+		{"score", "", 7, true},
+		{"unknown", "", 0, false},
+	}
+	for _, c := range cases {
+		strVal, numVal, numeric := eventField(e, c.field)
+		if strVal != c.wantStr || numVal != c.wantNum || numeric != c.wantIsNum {
+			t.Errorf("eventField(e, %q) = (%q, %v, %v), want (%q, %v, %v)",
+				c.field, strVal, numVal, numeric, c.wantStr, c.wantNum, c.wantIsNum)
+		}
+	}
+}
+
+func TestBusSubscribePublishFilters(t *testing.T) {
+	bus := NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := bus.Subscribe(ctx, "type='ProbeScored' AND score>10")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	bus.Publish(Event{Type: ProbeScored, Score: 5})  // filtered out: score too low
+	bus.Publish(Event{Type: ProbeIssued, Score: 20}) // filtered out: wrong type
+	bus.Publish(Event{Type: ProbeScored, Score: 20}) // delivered
+	bus.Publish(Event{Type: ProbeScored, Score: 30}) // delivered
+
+	select {
+	case e := <-events:
+		if e.Score != 20 {
+			t.Errorf("first delivered event score = %d, want 20", e.Score)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a matching event")
+	}
+	select {
+	case e := <-events:
+		if e.Score != 30 {
+			t.Errorf("second delivered event score = %d, want 30", e.Score)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a matching event")
+	}
+
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Errorf("got unexpected third event %+v, want the channel empty", e)
+		}
+	default:
+	}
+}
+
+func TestBusSubscribeClosesOnCancel(t *testing.T) {
+	bus := NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := bus.Subscribe(ctx, "")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("channel delivered a value instead of closing")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the channel to close after cancel")
+	}
+
+	// A publish after the subscriber is gone must not panic or block.
+	bus.Publish(Event{Type: ProbeScored})
+}
+
+func TestBusPublishDropsOnFullChannel(t *testing.T) {
+	bus := NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := bus.Subscribe(ctx, ""); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Far more than the subscriber channel's buffer, with nothing
+		// draining it: Publish must drop rather than block.
+		for i := 0; i < 1000; i++ {
+			bus.Publish(Event{Type: ProbeScored, Score: i})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked instead of dropping events for a full subscriber channel")
+	}
+}
@@ -5,27 +5,37 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"os/exec"
-	"runtime"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
-	defaultCmd     = "sh curl.sh"
-	defaultRight   = " "
-	defaultWrong   = "^"
-	defaultCharset = "0123456789abcdef"
-	defaultInit    = ""
-	defaultThreads = 10
-	defaultDelay   = 0
-	defaultDebug   = false
+	defaultCmd      = "sh curl.sh"
+	defaultRight    = " "
+	defaultWrong    = "^"
+	defaultCharset  = "0123456789abcdef"
+	defaultInit     = ""
+	defaultThreads  = 10
+	defaultDelay    = 0
+	defaultDebug    = false
+	defaultOracle   = "exec"
+	defaultSamples  = 5
+	defaultAlpha    = 0.05
+	defaultReprobe  = 3
+	defaultFormat   = "text"
+	defaultMaxDepth = 0
 )
 
 // Global variable for debugging
@@ -39,46 +49,6 @@ func log(message string) {
 	fmt.Println(message)
 }
 
-// Dirty trick to run Cmd with unknown amount of params
-func run(cmd string, param string) (int, error) {
-	log("Executing: " + cmd + " with " + param)
-
-	// Split Cmd
-	v := strings.Split(cmd, " ")
-	guess := exec.Command(v[0], v[1:]...)
-
-	stdin, _ := guess.StdinPipe()
-	io.WriteString(stdin, param+"\n")
-	out, err := guess.Output()
-	if err != nil {
-		return -1, err
-	}
-
-	log("Output: " + string(out))
-	score, err := strconv.Atoi(strings.Split(string(out), "\n")[0])
-	if err != nil {
-		return -1, err
-	}
-
-	return score, nil
-}
-
-// Gets score if "repeat" tries get the same result
-func score(cmd string, param string, repeat int) (int, error) {
-	res, _ := run(cmd, param)
-	log("Score: " + strconv.Itoa(res))
-	for i := 0; i < repeat-1; i++ {
-		newres, _ := run(cmd, param)
-		log("Score: " + strconv.Itoa(newres))
-		if res != newres {
-			m := "Site seems to be unestable"
-			log(m)
-			return -1, errors.New(m)
-		}
-	}
-	return res, nil
-}
-
 // Gets longest key (more close to get a result)
 func sample(m map[string]string) (string, error) {
 	var l int
@@ -105,19 +75,58 @@ func isAlreadyResult(m map[string]bool, s string) bool {
 	return false
 }
 
+// matchesPrefix reports whether term is still compatible with prefix: an
+// empty prefix matches everything, and a term shorter than prefix only
+// needs to agree on the overlapping part.
+func matchesPrefix(term, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	n := len(term)
+	if len(prefix) < n {
+		n = len(prefix)
+	}
+	return term[:n] == prefix[:n]
+}
+
 // Main func
 func main() {
 	// Params parsing
-	cmd := flag.String("cmd", defaultCmd, "command to run, parameter sent via stdin")
+	cmd := flag.String("cmd", defaultCmd, "command to run, parameter sent via stdin (oracle=exec)")
 	right := flag.String("right", defaultRight, "term that makes cmd to give a right response")
 	wrong := flag.String("wrong", defaultWrong, "term that makes cmd to give a wrong response")
 	charset := flag.String("charset", defaultCharset, "charset we use for guessing")
 	init := flag.String("init", defaultInit, "Initial search string")
 	threads := flag.Int("threads", defaultThreads, "amount of threads to use")
-	delay := flag.Int("delay", defaultDelay, "delay between connections")
+	delay := flag.Int("delay", defaultDelay, "delay (ms) between connections, per worker")
 	debugFlag := flag.Bool("debug", defaultDebug, "print verbose output (debugging)")
+	oracleKind := flag.String("oracle", defaultOracle, "oracle backend to use: exec, http, timing")
+	url := flag.String("url", "", "URL template for oracle=http/timing, use {{.Payload}}")
+	method := flag.String("method", "GET", "HTTP method for oracle=http/timing")
+	body := flag.String("body", "", "HTTP body template for oracle=http/timing, use {{.Payload}}")
+	scoreBy := flag.String("score-by", "status", "oracle=http scoring: status, length or regex")
+	regex := flag.String("regex", "", "regex used when -score-by=regex")
+	timingSamples := flag.Int("timing-samples", 5, "requests to issue per probe for oracle=timing")
+	samples := flag.Int("samples", defaultSamples, "probes per payload used to calibrate right/wrong scores")
+	alpha := flag.Float64("alpha", defaultAlpha, "significance level required to trust the calibration")
+	reprobe := flag.Int("reprobe", defaultReprobe, "times to re-probe an ambiguous guess before deciding by majority vote")
+	format := flag.String("format", defaultFormat, "progress output: text or json")
+	checkpoint := flag.String("checkpoint", "", "path to write a resumable JSON checkpoint after every branch")
+	resume := flag.String("resume", "", "resume a previous search from a checkpoint file")
+	maxDepth := flag.Int("max-depth", defaultMaxDepth, "stop extending candidates past this length, 0 for unlimited (for sharding)")
+	onlyPrefix := flag.String("only-prefix", "", "only explore candidates compatible with this prefix (for sharding)")
+	merge := flag.String("merge", "", "comma-separated checkpoint files to merge into -checkpoint, instead of running a search")
+	var headers headerList
+	flag.Var(&headers, "header", "extra HTTP header as 'Key: Value' (repeatable)")
 	flag.Parse()
 
+	if *merge != "" {
+		if err := mergeCheckpointFiles(strings.Split(*merge, ","), *checkpoint); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
 	// If debug is activated, we disable the regular output
 	debug = *debugFlag
 	var quiet = false
@@ -125,12 +134,121 @@ func main() {
 		quiet = true
 	}
 
+	ocfg := oracleConfig{
+		kind:          *oracleKind,
+		cmd:           *cmd,
+		url:           *url,
+		method:        *method,
+		body:          *body,
+		scoreBy:       *scoreBy,
+		regex:         *regex,
+		timingSamples: *timingSamples,
+		headers:       headers.Header(),
+	}
+	oracle, err := buildOracle(ocfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// SIGINT cancels the search's context, which unwinds the current
+	// branch's errgroup and lets guessIt flush a final checkpoint.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	calibrated, err := Calibrate(ctx, oracle, *right, *wrong, *samples, *alpha)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	oracleHash := HashOracleConfig(ocfg)
+	var store Store
+	if *checkpoint != "" {
+		store = &FileStore{Path: *checkpoint}
+	}
+
+	var cp Checkpoint
+	if *resume != "" {
+		loaded, err := (&FileStore{Path: *resume}).Load()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if loaded.OracleHash != oracleHash {
+			fmt.Println("checkpoint: oracle configuration does not match, refusing to resume")
+			return
+		}
+		cp = loaded
+		if store == nil {
+			store = &FileStore{Path: *resume}
+		}
+	} else {
+		cp = Checkpoint{
+			Pending:    map[string]string{*init: "->"},
+			Res:        map[string]bool{},
+			Exhausted:  map[string][]string{},
+			OracleHash: oracleHash,
+		}
+	}
+
+	bus := NewBus()
+	if !quiet {
+		subCtx, cancel := context.WithCancel(ctx)
+		events, err := bus.Subscribe(subCtx, "")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeEvents(events, *format)
+		}()
+		defer func() {
+			cancel()
+			wg.Wait()
+		}()
+	}
+
 	// Call to the main func
-	guessIt(cmd, right, wrong, charset, init, threads, delay, quiet)
+	if _, err := guessIt(ctx, calibrated, charset, init, threads, delay, *reprobe, bus, cp, store, *maxDepth, *onlyPrefix); err != nil {
+		fmt.Println(err)
+	}
 }
 
-// Gets arguments from map instead of command line (for testing purposes)
-func guessItMap(param map[string]string) map[string]bool {
+// consumeEvents is the CLI's default subscriber: it reproduces the
+// terminal overprinting this tool has always done (-format=text), or
+// streams one JSON object per event (-format=json) for other tools to
+// consume. It returns once events is closed.
+func consumeEvents(events <-chan Event, format string) {
+	for e := range events {
+		if format == "json" {
+			if data, err := json.Marshal(e); err == nil {
+				fmt.Println(string(data))
+			}
+			continue
+		}
+
+		switch e.Type {
+		case ResultFound:
+			fmt.Printf("\r%s\n", e.Term)
+		case CandidateExtended, BranchExhausted:
+			fmt.Printf("\r%s", e.Term)
+		}
+	}
+
+	if format != "json" {
+		fmt.Printf("\r                                                    \r")
+	}
+}
+
+// Gets arguments from map instead of command line (for testing purposes).
+// A nil oracle makes it build an ExecOracle from the "cmd" entry, as before.
+// ctx lets a library caller cancel the guess; pass context.Background() if
+// that doesn't apply.
+func guessItMap(ctx context.Context, param map[string]string, oracle Oracle) map[string]bool {
 	var cmd = defaultCmd
 	var right = defaultRight
 	var wrong = defaultWrong
@@ -139,6 +257,9 @@ func guessItMap(param map[string]string) map[string]bool {
 	var threads = defaultThreads
 	var delay = defaultDelay
 	var debugFlag = defaultDebug
+	var samples = defaultSamples
+	var alpha = defaultAlpha
+	var reprobe = defaultReprobe
 	var err error
 
 	for name, value := range param {
@@ -170,33 +291,103 @@ func guessItMap(param map[string]string) map[string]bool {
 			} else {
 				debug = debugFlag
 			}
+		case "samples":
+			samples, err = strconv.Atoi(value)
+			if err != nil {
+				samples = defaultSamples
+			}
+		case "alpha":
+			alpha, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				alpha = defaultAlpha
+			}
+		case "reprobe":
+			reprobe, err = strconv.Atoi(value)
+			if err != nil {
+				reprobe = defaultReprobe
+			}
 		}
 	}
 
-	return guessIt(&cmd, &right, &wrong, &charset, &init, &threads, &delay, true)
+	if oracle == nil {
+		oracle = &ExecOracle{Cmd: cmd}
+	}
+
+	calibrated, err := Calibrate(ctx, oracle, right, wrong, samples, alpha)
+	if err != nil {
+		return nil
+	}
+
+	cp := Checkpoint{
+		Pending:   map[string]string{init: "->"},
+		Res:       map[string]bool{},
+		Exhausted: map[string][]string{},
+	}
+
+	res, _ := guessIt(ctx, calibrated, &charset, &init, &threads, &delay, reprobe, NewBus(), cp, nil, defaultMaxDepth, "")
+	return res
 }
 
 // Real core
-func guessIt(cmd, right, wrong, charset, init *string, threads, delay *int, quiet bool) map[string]bool {
-	// Check stability
-	log("Checking stability: Right Guess")
-	scoreRight, err1 := score(*cmd, *right, 5)
-	log("Checking stability: Wrong Guess")
-	_, err2 := score(*cmd, *wrong, 5)
-	if (err1 != nil) || (err2 != nil) {
-		if !quiet {
-			m := "Unestable"
-			log(m)
-			fmt.Println(m)
+func guessIt(ctx context.Context, oracle *CalibratedOracle, charset, init *string, threads, delay *int, reprobe int, bus *Bus, cp Checkpoint, store Store, maxDepth int, onlyPrefix string) (map[string]bool, error) {
+	// Resume (or start) the frontier, the results and the per-branch
+	// exhausted-char sets from the checkpoint.
+	pending := cp.Pending
+	if pending == nil {
+		pending = map[string]string{*init: "->"}
+	}
+	res := cp.Res
+	if res == nil {
+		res = map[string]bool{}
+	}
+	exhausted := cp.Exhausted
+	if exhausted == nil {
+		exhausted = map[string][]string{}
+	}
+
+	// shardOverflow accumulates Right guesses that fell outside this
+	// shard's -max-depth/-only-prefix. They are never fed back into the
+	// live pending frontier (this shard would just bounce them straight
+	// back out again), only persisted on the next save so another
+	// shard/run can resume them.
+	shardOverflow := map[string]string{}
+
+	// anchored tracks which keys are known to sit at the true start of
+	// the secret. A -> candidate is only a genuine prefix of the secret
+	// (and so only safe to filter by -only-prefix) once its own left
+	// edge is known to be the start; a <- candidate never is, until the
+	// moment it turns out nothing more can be prepended to it. *init is
+	// anchored only when it is empty, i.e. the search begins at the true
+	// start rather than at a known substring somewhere in the middle.
+	initAnchored := *init == ""
+	anchored := map[string]bool{*init: initAnchored}
+	isAnchored := func(key string) bool {
+		if a, ok := anchored[key]; ok {
+			return a
 		}
+		return initAnchored
 	}
 
-	// Prepare a Set for substrings and a Set for results
-	var pending = make(map[string]string)
-	var tmp = make(map[string]bool)
-	var res = make(map[string]bool)
 	var mtx sync.Mutex
-	pending[*init] = "->"
+
+	save := func() {
+		if store == nil {
+			return
+		}
+		savedPending := pending
+		if len(shardOverflow) > 0 {
+			savedPending = make(map[string]string, len(pending)+len(shardOverflow))
+			for k, v := range pending {
+				savedPending[k] = v
+			}
+			for k, v := range shardOverflow {
+				savedPending[k] = v
+			}
+		}
+		if err := store.Save(Checkpoint{Pending: savedPending, Res: res, Exhausted: exhausted, OracleHash: cp.OracleHash}); err != nil {
+			log("checkpoint: save failed: " + err.Error())
+		}
+	}
 
 	// While no pending strings to test, go for it
 	for len(pending) > 0 {
@@ -212,22 +403,38 @@ func guessIt(cmd, right, wrong, charset, init *string, threads, delay *int, quie
 			continue
 		}
 
-		// Prepare Wait Group
-		var wg sync.WaitGroup
-		wg.Add(len(*charset))
+		branch := key + "\x00" + dir
+		knownWrong := map[string]bool{}
+		for _, c := range exhausted[branch] {
+			knownWrong[c] = true
+		}
 
-		// Goroutines guessing
-		for _, r := range *charset {
+		var tmp = make(map[string]bool)
+		for c := range knownWrong {
+			tmp["skip:"+c] = true
+		}
+		newlyWrong := map[string]bool{}
 
-			// Wait until we have available threads
-			for runtime.NumGoroutine() >= (*threads)+1 {
-				time.Sleep(100 * time.Millisecond)
-			}
+		// Fan out one task per remaining charset character, throttled by
+		// a weighted semaphore sized to *threads instead of polling
+		// NumGoroutine.
+		sem := semaphore.NewWeighted(int64(*threads))
+		g, gctx := errgroup.WithContext(ctx)
 
+		for _, r := range *charset {
 			c := string(r)
-			go func(pending map[string]string, cmd string, key string, dir string, c string, right int, res map[string]bool) {
-				// Call done when gorouting ends
-				defer wg.Done()
+			if knownWrong[c] {
+				continue
+			}
+			g.Go(func() error {
+				if err := sem.Acquire(gctx, 1); err != nil {
+					return err
+				}
+				defer sem.Release(1)
+
+				if *delay > 0 {
+					time.Sleep(time.Duration(*delay) * time.Millisecond)
+				}
 
 				// Get term to test
 				var term string
@@ -237,53 +444,142 @@ func guessIt(cmd, right, wrong, charset, init *string, threads, delay *int, quie
 					term = c + key
 				}
 
-				// Calculate score
-				score, _ := run(cmd, term)
-				log("Guessing " + term + " with score " + strconv.Itoa(score))
+				bus.Publish(Event{Type: ProbeIssued, Time: time.Now(), Term: term, Direction: dir})
+
+				// Calculate score, re-probing ambiguous guesses up to
+				// reprobe times and deciding by majority vote. A probe
+				// that keeps failing transiently after retrying is
+				// treated as an inconclusive (wrong) guess rather than
+				// aborting the whole branch; a non-transient error still
+				// aborts the search.
+				s, err := probeWithRetry(gctx, oracle, term)
+				if err != nil {
+					if !isTransientProbeErr(err) {
+						return err
+					}
+					bus.Publish(Event{Type: StabilityWarning, Time: time.Now(), Term: term,
+						Message: "probe kept failing transiently, treating as wrong: " + err.Error()})
+					mtx.Lock()
+					tmp[term] = true
+					newlyWrong[c] = true
+					mtx.Unlock()
+					return nil
+				}
+				class := oracle.Classify(s)
+				log("Guessing " + term + " with score " + strconv.Itoa(s) + " (" + class.String() + ")")
+
+				if class == Ambiguous {
+					votes := map[Classification]int{class: 1}
+					for i := 1; i < reprobe; i++ {
+						s, err = probeWithRetry(gctx, oracle, term)
+						if err != nil {
+							if !isTransientProbeErr(err) {
+								return err
+							}
+							log(term + " reprobe kept failing transiently, using votes collected so far")
+							break
+						}
+						votes[oracle.Classify(s)]++
+					}
+					class = majorityClass(votes)
+					log(term + " was ambiguous, majority vote: " + class.String())
+
+					if class == Ambiguous {
+						bus.Publish(Event{Type: StabilityWarning, Time: time.Now(), Term: term,
+							Message: "stayed ambiguous after reprobing, treating as wrong"})
+						class = Wrong
+					}
+				}
+
+				bus.Publish(Event{Type: ProbeScored, Time: time.Now(), Term: term, Direction: dir, Score: s, Classification: class})
+
+				// A shard only owns candidates within its -max-depth and
+				// -only-prefix; anything outside of it belongs to another
+				// shard, not to this branch's wrong guesses. -only-prefix
+				// can only be trusted against a -> candidate once key's
+				// own left edge is known to be the secret's true start
+				// (isAnchored); a <- candidate is always right-aligned to
+				// the still-unknown start, so it is checked against
+				// -only-prefix only once its branch fully resolves, see
+				// below.
+				inShard := maxDepth <= 0 || len(term) <= maxDepth
+				if dir == "->" && isAnchored(key) {
+					inShard = inShard && matchesPrefix(term, onlyPrefix)
+				}
 
 				// Save results for next iteration
-				if score == right {
+				mtx.Lock()
+				switch {
+				case class == Right && inShard:
 					log(term + " was a RIGHT guess")
-					mtx.Lock()
 					pending[term] = dir
-					mtx.Unlock()
-				} else {
+					anchored[term] = isAnchored(key)
+				case class == Right:
+					// Right, but outside this shard: it is neither a dead
+					// end nor this shard's to finish, so it must not count
+					// towards branch exhaustion. Stash it for the next
+					// checkpoint save instead, for another shard/run to
+					// pick up and resume.
+					log(term + " was a RIGHT guess, but outside this shard; leaving for another shard")
+					shardOverflow[term] = dir
+				default:
 					log(term + " was a wrong guess")
-					mtx.Lock()
 					tmp[term] = true
-					mtx.Unlock()
+					newlyWrong[c] = true
 				}
-			}(pending, *cmd, key, dir, c, scoreRight, res)
+				mtx.Unlock()
+
+				return nil
+			})
 		}
 
-		// Wait for goroutines to finish
-		wg.Wait()
+		// Wait for the whole branch, aborting on the first real error
+		// (including the context being cancelled by SIGINT).
+		if err := g.Wait(); err != nil {
+			mtx.Lock()
+			pending[key] = dir
+			if len(newlyWrong) > 0 {
+				merged := append([]string{}, exhausted[branch]...)
+				for c := range newlyWrong {
+					merged = append(merged, c)
+				}
+				exhausted[branch] = merged
+			}
+			mtx.Unlock()
+			save()
+			return res, err
+		}
+
+		// This branch is fully resolved, its exhausted-char bookkeeping
+		// is no longer needed.
+		delete(exhausted, branch)
 
 		// If all chars were errors, we reached the start/end of a string
 		if len(tmp) == len(*charset) {
-			if dir == "->" {
+			switch {
+			case dir == "->":
 				log("Guessing in <- direction")
 				pending[key] = "<-"
-			} else {
+				bus.Publish(Event{Type: BranchExhausted, Time: time.Now(), Term: key, Direction: dir})
+			case matchesPrefix(key, onlyPrefix):
 				log("Finish guessing")
 				res[key] = true
-				if !quiet {
-					fmt.Printf("\r%s\n", key)
-				}
+				bus.Publish(Event{Type: ResultFound, Time: time.Now(), Term: key})
+			default:
+				// Only now, with key fully resolved left-to-right, does
+				// comparing it against -only-prefix mean anything. It
+				// doesn't belong to this shard; leave it for another
+				// shard/run to claim instead of silently dropping it or
+				// wrongly recording it as this shard's result.
+				log(key + " finished guessing but outside this shard's -only-prefix; leaving for another shard")
+				shardOverflow[key] = dir
 			}
 		} else {
-			if !quiet {
-				fmt.Printf("\r%s", key)
-			}
+			bus.Publish(Event{Type: CandidateExtended, Time: time.Now(), Term: key, Direction: dir})
 		}
-		// Clean temporal map
-		tmp = make(map[string]bool)
-	}
 
-	// Clean the last try
-	if !quiet {
-		fmt.Printf("\r                                                    \r")
+		save()
 	}
 
-	return res
+	return res, nil
 }
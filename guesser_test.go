@@ -0,0 +1,155 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMatchesPrefix(t *testing.T) {
+	cases := []struct {
+		term, prefix string
+		want         bool
+	}{
+		{"abc", "", true},
+		{"", "abc", true},
+		{"abc", "ab", true},
+		{"ab", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "xyz", false},
+	}
+	for _, c := range cases {
+		if got := matchesPrefix(c.term, c.prefix); got != c.want {
+			t.Errorf("matchesPrefix(%q, %q) = %v, want %v", c.term, c.prefix, got, c.want)
+		}
+	}
+}
+
+// containsOracle scores a payload by whether it literally occurs in secret,
+// the classic blind-substring probe this tool is built around. The two
+// calibration markers are scored as fixed high/low regardless of secret, so
+// Calibrate can separate them deterministically.
+type containsOracle struct {
+	secret, right, wrong string
+}
+
+func (o *containsOracle) Probe(_ context.Context, payload string) (int, error) {
+	switch payload {
+	case o.right:
+		return 100, nil
+	case o.wrong:
+		return 0, nil
+	}
+	if strings.Contains(o.secret, payload) {
+		return 100, nil
+	}
+	return 0, nil
+}
+
+// TestGuessItMaxDepthShard is a regression test for a bug where a Right
+// guess that fell outside the shard's -max-depth was folded in with the
+// branch's genuinely wrong guesses. That made a branch look fully
+// exhausted when the real reason was shard exclusion, recording
+// incomplete prefixes as finished results.
+func TestGuessItMaxDepthShard(t *testing.T) {
+	ctx := context.Background()
+	oracle := &containsOracle{secret: "abcd", right: " ", wrong: "^"}
+	cal, err := Calibrate(ctx, oracle, " ", "^", 5, 0.05)
+	if err != nil {
+		t.Fatalf("Calibrate: %v", err)
+	}
+
+	charset := "abcd"
+	init := ""
+	threads := 4
+	delay := 0
+	store := &MemStore{}
+
+	res, err := guessIt(ctx, cal, &charset, &init, &threads, &delay, defaultReprobe, NewBus(), Checkpoint{}, store, 2, "")
+	if err != nil {
+		t.Fatalf("guessIt: %v", err)
+	}
+
+	for key := range res {
+		if len(key) < len(charset) {
+			t.Errorf("res contains incomplete prefix %q recorded as a finished result", key)
+		}
+	}
+
+	cp, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load: %v", err)
+	}
+	for key := range cp.Res {
+		if len(key) < len(charset) {
+			t.Errorf("checkpoint.Res contains incomplete prefix %q recorded as a finished result", key)
+		}
+	}
+}
+
+// TestGuessItOnlyPrefixLeftDirection is a regression test for a bug where
+// -only-prefix was compared against <- candidates while they were still
+// right-aligned to the (unknown) start of the secret, instead of against
+// the fully resolved string. init is a middle substring here, so the
+// secret is reachable only by first growing right to a dead end and then
+// growing left from there.
+func TestGuessItOnlyPrefixLeftDirection(t *testing.T) {
+	ctx := context.Background()
+	oracle := &containsOracle{secret: "XYZABC", right: " ", wrong: "^"}
+	cal, err := Calibrate(ctx, oracle, " ", "^", 5, 0.05)
+	if err != nil {
+		t.Fatalf("Calibrate: %v", err)
+	}
+
+	charset := "ABCXYZ"
+	init := "AB"
+	threads := 4
+	delay := 0
+
+	res, err := guessIt(ctx, cal, &charset, &init, &threads, &delay, defaultReprobe, NewBus(), Checkpoint{}, nil, defaultMaxDepth, "XY")
+	if err != nil {
+		t.Fatalf("guessIt: %v", err)
+	}
+	if !res["XYZABC"] {
+		t.Errorf("res = %v, want it to contain %q", res, "XYZABC")
+	}
+}
+
+// TestGuessItOnlyPrefixLeftDirectionExcludesOtherShards checks the flip
+// side: a fully resolved <- result whose start does not match
+// -only-prefix must not be recorded in this shard's res, but should still
+// be persisted for another shard/run to claim.
+func TestGuessItOnlyPrefixLeftDirectionExcludesOtherShards(t *testing.T) {
+	ctx := context.Background()
+	oracle := &containsOracle{secret: "XYZABC", right: " ", wrong: "^"}
+	cal, err := Calibrate(ctx, oracle, " ", "^", 5, 0.05)
+	if err != nil {
+		t.Fatalf("Calibrate: %v", err)
+	}
+
+	charset := "ABCXYZ"
+	init := "AB"
+	threads := 4
+	delay := 0
+	store := &MemStore{}
+
+	res, err := guessIt(ctx, cal, &charset, &init, &threads, &delay, defaultReprobe, NewBus(), Checkpoint{}, store, defaultMaxDepth, "ZZ")
+	if err != nil {
+		t.Fatalf("guessIt: %v", err)
+	}
+	if res["XYZABC"] {
+		t.Errorf("res = %v, want %q excluded by a non-matching -only-prefix", res, "XYZABC")
+	}
+
+	cp, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load: %v", err)
+	}
+	if _, ok := cp.Pending["XYZABC"]; !ok {
+		t.Errorf("checkpoint.Pending = %v, want it to keep %q for another shard/run", cp.Pending, "XYZABC")
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Oracle scores a single guess payload against the target. A higher or
+// lower score means nothing on its own: callers compare it against the
+// score obtained for the "right" and "wrong" calibration payloads.
+type Oracle interface {
+	Probe(ctx context.Context, payload string) (score int, err error)
+}
+
+// maxProbeRetries bounds how many times probeWithRetry retries a single
+// probe that keeps failing with a transient error.
+const maxProbeRetries = 3
+
+// isTransientProbeErr reports whether err looks like a transient blip
+// (timeout, temporary network condition) as opposed to a real failure of
+// the oracle itself. A cancelled context is deliberately not transient:
+// that means the caller asked the whole search to stop.
+func isTransientProbeErr(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
+}
+
+// probeWithRetry calls oracle.Probe, retrying with a short backoff while
+// the error looks transient. A non-transient error (or a cancelled ctx)
+// is returned immediately.
+func probeWithRetry(ctx context.Context, oracle Oracle, payload string) (int, error) {
+	var lastErr error
+	for attempt := 0; attempt < maxProbeRetries; attempt++ {
+		s, err := oracle.Probe(ctx, payload)
+		if err == nil {
+			return s, nil
+		}
+		lastErr = err
+		if !isTransientProbeErr(err) {
+			return -1, err
+		}
+		if attempt == maxProbeRetries-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 20 * time.Millisecond):
+		}
+	}
+	return -1, lastErr
+}
+
+// ExecOracle scores a payload by running Cmd with the payload on stdin
+// and reading back a score as the first line of stdout. This is the
+// original behaviour of the tool, now expressed as an Oracle.
+type ExecOracle struct {
+	Cmd string
+}
+
+// Probe implements Oracle.
+func (o *ExecOracle) Probe(ctx context.Context, payload string) (int, error) {
+	log("Executing: " + o.Cmd + " with " + payload)
+
+	v := strings.Split(o.Cmd, " ")
+	guess := exec.CommandContext(ctx, v[0], v[1:]...)
+
+	stdin, _ := guess.StdinPipe()
+	io.WriteString(stdin, payload+"\n")
+	out, err := guess.Output()
+	if err != nil {
+		return -1, err
+	}
+
+	log("Output: " + string(out))
+	score, err := strconv.Atoi(strings.Split(string(out), "\n")[0])
+	if err != nil {
+		return -1, err
+	}
+
+	return score, nil
+}
+
+// templatePayload renders s as a text/template with a {{.Payload}} field,
+// falling back to the literal string if s has no template actions.
+func templatePayload(name, s, payload string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	tmpl, err := template.New(name).Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Payload string }{payload}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// HTTPOracle scores a payload by issuing an HTTP request built from a URL
+// template (and optionally a body template) containing {{.Payload}}, and
+// deriving a score from the response via ScoreBy.
+type HTTPOracle struct {
+	Client      *http.Client
+	Method      string
+	URLTemplate string
+	Body        string
+	Headers     map[string]string
+
+	// ScoreBy selects how the response is turned into a score: "status"
+	// (HTTP status code), "length" (response body length), or "regex"
+	// (number of Regexp matches in the response body).
+	ScoreBy string
+	Regex   *regexp.Regexp
+}
+
+// Probe implements Oracle.
+func (o *HTTPOracle) Probe(ctx context.Context, payload string) (int, error) {
+	resp, body, err := o.do(ctx, payload)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	switch o.ScoreBy {
+	case "status":
+		return resp.StatusCode, nil
+	case "length":
+		return len(body), nil
+	case "regex":
+		if o.Regex == nil {
+			return -1, errors.New("HTTPOracle: ScoreBy=regex requires Regex")
+		}
+		return len(o.Regex.FindAll(body, -1)), nil
+	default:
+		return -1, fmt.Errorf("HTTPOracle: unknown ScoreBy %q", o.ScoreBy)
+	}
+}
+
+// do builds and issues the HTTP request for payload, returning the
+// response (with its body already drained into the second return value).
+func (o *HTTPOracle) do(ctx context.Context, payload string) (*http.Response, []byte, error) {
+	url, err := templatePayload("url", o.URLTemplate, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := templatePayload("body", o.Body, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	method := o.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+
+	return resp, data, nil
+}
+
+// oracleConfig bundles every flag needed to build any of the Oracle
+// implementations, so main can stay a thin front-end over buildOracle.
+type oracleConfig struct {
+	kind          string
+	cmd           string
+	url           string
+	method        string
+	body          string
+	scoreBy       string
+	regex         string
+	timingSamples int
+	headers       map[string]string
+}
+
+// buildOracle selects and configures an Oracle implementation from c.kind.
+func buildOracle(c oracleConfig) (Oracle, error) {
+	switch c.kind {
+	case "", "exec":
+		return &ExecOracle{Cmd: c.cmd}, nil
+	case "http":
+		if c.url == "" {
+			return nil, errors.New("oracle=http requires -url")
+		}
+		o := &HTTPOracle{
+			Method:      c.method,
+			URLTemplate: c.url,
+			Body:        c.body,
+			Headers:     c.headers,
+			ScoreBy:     c.scoreBy,
+		}
+		if c.scoreBy == "regex" {
+			re, err := regexp.Compile(c.regex)
+			if err != nil {
+				return nil, err
+			}
+			o.Regex = re
+		}
+		return o, nil
+	case "timing":
+		if c.url == "" {
+			return nil, errors.New("oracle=timing requires -url")
+		}
+		return &TimingOracle{
+			HTTPOracle: HTTPOracle{
+				Method:      c.method,
+				URLTemplate: c.url,
+				Body:        c.body,
+				Headers:     c.headers,
+			},
+			Samples: c.timingSamples,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown oracle %q", c.kind)
+	}
+}
+
+// headerList implements flag.Value to collect repeated -header "Key: Value"
+// flags into a map suitable for HTTPOracle/TimingOracle.
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// Header turns the collected "Key: Value" entries into a header map.
+func (h *headerList) Header() map[string]string {
+	out := make(map[string]string, len(*h))
+	for _, entry := range *h {
+		k, v, found := strings.Cut(entry, ":")
+		if !found {
+			continue
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out
+}
+
+// TimingOracle scores a payload by the round-trip time of the underlying
+// HTTP request, enabling blind time-based inference without the per-char
+// process fork/exec overhead of ExecOracle.
+type TimingOracle struct {
+	HTTPOracle
+	Samples int
+}
+
+// Probe issues Samples requests and returns the median round-trip time in
+// milliseconds, discretized to the millisecond.
+func (o *TimingOracle) Probe(ctx context.Context, payload string) (int, error) {
+	samples := o.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+
+	times := make([]int, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		resp, body, err := o.do(ctx, payload)
+		elapsed := time.Since(start)
+		if err != nil {
+			return -1, err
+		}
+		resp.Body.Close()
+		_ = body
+		times = append(times, int(elapsed.Milliseconds()))
+	}
+
+	sort.Ints(times)
+	return times[len(times)/2], nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestTemplatePayload(t *testing.T) {
+	cases := []struct {
+		name    string
+		s       string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"literal, no template action", "plain", "ignored", "plain", false},
+		{"substitutes payload", "x{{.Payload}}y", "PAY", "xPAYy", false},
+		{"invalid template", "{{.Broken", "PAY", "", true},
+	}
+	for _, c := range cases {
+		got, err := templatePayload("t", c.s, c.payload)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%s: want error, got nil", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: templatePayload() = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHeaderListHeader(t *testing.T) {
+	var h headerList
+	h.Set("Content-Type: application/json")
+	h.Set("X-Test:  spaced  ")
+	h.Set("not-a-header")
+
+	got := h.Header()
+	want := map[string]string{
+		"Content-Type": "application/json",
+		"X-Test":       "spaced",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Header() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Header()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+// execOracleScript writes a tiny shell script that echoes the length of its
+// first stdin line, and returns its path. ExecOracle.Cmd is split on spaces
+// with no shell quoting, so the script takes no arguments and Probe.Cmd can
+// stay a single token.
+func execOracleScript(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "echo-len.sh")
+	script := "#!/bin/sh\nread line\necho ${#line}\n"
+	if err := os.WriteFile(path, []byte(script), 0o700); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestExecOracleProbe(t *testing.T) {
+	o := &ExecOracle{Cmd: execOracleScript(t)}
+	score, err := o.Probe(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if score != 3 {
+		t.Errorf("Probe() = %d, want 3", score)
+	}
+}
+
+func TestExecOracleProbeCommandError(t *testing.T) {
+	o := &ExecOracle{Cmd: "this-command-does-not-exist-xyz"}
+	if _, err := o.Probe(context.Background(), "abc"); err == nil {
+		t.Fatal("Probe with a nonexistent command: want error, got nil")
+	}
+}
+
+func TestHTTPOracleProbeScoreBy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, 16)
+		n, _ := r.Body.Read(body)
+		payload := string(body[:n])
+		if payload == "right" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		w.Write([]byte("aaa" + payload))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		scoreBy string
+		regex   *regexp.Regexp
+		payload string
+		want    int
+	}{
+		{"status", "status", nil, "right", http.StatusOK},
+		{"status wrong", "status", nil, "wrong", http.StatusForbidden},
+		{"length", "length", nil, "right", len("aaaright")},
+		{"regex", "regex", regexp.MustCompile("a"), "right", 3},
+	}
+	for _, c := range cases {
+		o := &HTTPOracle{
+			Method:      "POST",
+			URLTemplate: srv.URL,
+			Body:        "{{.Payload}}",
+			ScoreBy:     c.scoreBy,
+			Regex:       c.regex,
+		}
+		got, err := o.Probe(context.Background(), c.payload)
+		if err != nil {
+			t.Fatalf("%s: Probe: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: Probe() = %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestHTTPOracleProbeUnknownScoreBy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	o := &HTTPOracle{URLTemplate: srv.URL, ScoreBy: "bogus"}
+	if _, err := o.Probe(context.Background(), "x"); err == nil {
+		t.Fatal("Probe with unknown ScoreBy: want error, got nil")
+	}
+}
+
+func TestTimingOracleProbe(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	o := &TimingOracle{
+		HTTPOracle: HTTPOracle{URLTemplate: srv.URL},
+		Samples:    3,
+	}
+	score, err := o.Probe(context.Background(), "x")
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if score < 0 {
+		t.Errorf("Probe() = %d, want a non-negative millisecond duration", score)
+	}
+	if requests != 3 {
+		t.Errorf("server saw %d requests, want Samples=3", requests)
+	}
+}
+
+func TestBuildOracle(t *testing.T) {
+	if o, err := buildOracle(oracleConfig{kind: "", cmd: "sh -c true"}); err != nil {
+		t.Errorf("kind=%q: unexpected error: %v", "", err)
+	} else if _, ok := o.(*ExecOracle); !ok {
+		t.Errorf("kind=%q: got %T, want *ExecOracle", "", o)
+	}
+
+	if _, err := buildOracle(oracleConfig{kind: "http"}); err == nil {
+		t.Error("kind=http without -url: want error, got nil")
+	}
+	if _, err := buildOracle(oracleConfig{kind: "http", url: "http://example.invalid", scoreBy: "regex", regex: "("}); err == nil {
+		t.Error("kind=http with invalid -regex: want error, got nil")
+	}
+	if _, err := buildOracle(oracleConfig{kind: "timing"}); err == nil {
+		t.Error("kind=timing without -url: want error, got nil")
+	}
+	if _, err := buildOracle(oracleConfig{kind: "bogus"}); err == nil {
+		t.Error("unknown kind: want error, got nil")
+	}
+}
+
+// fakeNetErr implements net.Error for exercising isTransientProbeErr
+// without depending on an actual network condition.
+type fakeNetErr struct {
+	timeout, temporary bool
+}
+
+func (e *fakeNetErr) Error() string   { return "fake net error" }
+func (e *fakeNetErr) Timeout() bool   { return e.timeout }
+func (e *fakeNetErr) Temporary() bool { return e.temporary }
+
+func TestIsTransientProbeErr(t *testing.T) {
+	if isTransientProbeErr(errors.New("boom")) {
+		t.Error("plain error: want not transient")
+	}
+	if !isTransientProbeErr(&fakeNetErr{timeout: true}) {
+		t.Error("net.Error with Timeout(): want transient")
+	}
+	if !isTransientProbeErr(&fakeNetErr{temporary: true}) {
+		t.Error("net.Error with Temporary(): want transient")
+	}
+	if isTransientProbeErr(&fakeNetErr{}) {
+		t.Error("net.Error with neither Timeout() nor Temporary(): want not transient")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Classification is the verdict returned by CalibratedOracle.Classify for
+// a single score.
+type Classification int
+
+const (
+	Wrong Classification = iota
+	Right
+	Ambiguous
+)
+
+func (c Classification) String() string {
+	switch c {
+	case Right:
+		return "Right"
+	case Wrong:
+		return "Wrong"
+	default:
+		return "Ambiguous"
+	}
+}
+
+// CalibratedOracle wraps an Oracle with the statistics gathered during a
+// calibration pass against known-right and known-wrong payloads, and
+// turns a raw score into a Right/Wrong/Ambiguous verdict instead of the
+// strict equality test the tool used to rely on.
+type CalibratedOracle struct {
+	Oracle
+
+	meanRight, meanWrong     float64
+	stddevRight, stddevWrong float64
+	threshold                float64
+	margin                   float64
+}
+
+// Calibrate probes right and wrong samples times each, requires the two
+// populations to be separable with p < alpha under a Welch's t-test, and
+// returns an Oracle that classifies future scores against that baseline.
+func Calibrate(ctx context.Context, oracle Oracle, right, wrong string, samples int, alpha float64) (*CalibratedOracle, error) {
+	if samples < 2 {
+		samples = 2
+	}
+
+	rightScores, err := probeN(ctx, oracle, right, samples)
+	if err != nil {
+		return nil, err
+	}
+	wrongScores, err := probeN(ctx, oracle, wrong, samples)
+	if err != nil {
+		return nil, err
+	}
+
+	meanRight, varRight := meanVariance(rightScores)
+	meanWrong, varWrong := meanVariance(wrongScores)
+
+	t := welchT(meanRight, varRight, len(rightScores), meanWrong, varWrong, len(wrongScores))
+	p := pValueFromT(t)
+	if p >= alpha {
+		return nil, fmt.Errorf("oracle calibration inconclusive: right/wrong scores are not separable (p=%.4f >= alpha=%.4f)", p, alpha)
+	}
+
+	stddevRight := math.Sqrt(varRight)
+	stddevWrong := math.Sqrt(varWrong)
+
+	return &CalibratedOracle{
+		Oracle:      oracle,
+		meanRight:   meanRight,
+		meanWrong:   meanWrong,
+		stddevRight: stddevRight,
+		stddevWrong: stddevWrong,
+		threshold:   (meanRight + meanWrong) / 2,
+		margin:      math.Min(stddevRight, stddevWrong) / 2,
+	}, nil
+}
+
+// Classify turns score into Right, Wrong or Ambiguous by comparing its
+// distance to each calibrated population mean against margin.
+func (c *CalibratedOracle) Classify(score int) Classification {
+	s := float64(score)
+	distRight := math.Abs(s - c.meanRight)
+	distWrong := math.Abs(s - c.meanWrong)
+
+	switch {
+	case distRight+c.margin < distWrong:
+		return Right
+	case distWrong+c.margin < distRight:
+		return Wrong
+	default:
+		return Ambiguous
+	}
+}
+
+// probeN issues n probes for payload and collects their scores, stopping
+// at the first error.
+func probeN(ctx context.Context, oracle Oracle, payload string, n int) ([]int, error) {
+	scores := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		s, err := oracle.Probe(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, s)
+	}
+	return scores, nil
+}
+
+// meanVariance returns the sample mean and variance of scores.
+func meanVariance(scores []int) (mean, variance float64) {
+	n := float64(len(scores))
+	if n == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, s := range scores {
+		sum += float64(s)
+	}
+	mean = sum / n
+
+	if n < 2 {
+		return mean, 0
+	}
+	var sq float64
+	for _, s := range scores {
+		d := float64(s) - mean
+		sq += d * d
+	}
+	variance = sq / (n - 1)
+	return mean, variance
+}
+
+// welchT computes Welch's t-statistic for two independent samples
+// described by their mean, variance and size.
+func welchT(meanA, varA float64, nA int, meanB, varB float64, nB int) float64 {
+	se := math.Sqrt(varA/float64(nA) + varB/float64(nB))
+	if se == 0 {
+		if meanA == meanB {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (meanA - meanB) / se
+}
+
+// pValueFromT approximates the two-tailed p-value of a t-statistic using
+// the standard normal distribution. This is accurate for the sample
+// sizes typically used to calibrate an oracle (tens of probes); for very
+// small -samples values it is conservative rather than exact.
+func pValueFromT(t float64) float64 {
+	return 2 * (1 - normalCDF(math.Abs(t)))
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * (1 + math.Erf(x/math.Sqrt2))
+}
+
+// majorityClass returns the classification with the most votes, breaking
+// ties conservatively in favour of Wrong, then Ambiguous, then Right.
+func majorityClass(votes map[Classification]int) Classification {
+	best := Ambiguous
+	bestN := -1
+	for _, c := range []Classification{Wrong, Ambiguous, Right} {
+		if votes[c] > bestN {
+			bestN = votes[c]
+			best = c
+		}
+	}
+	return best
+}
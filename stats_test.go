@@ -0,0 +1,103 @@
+// Copyright 2017 Jose Selvi <jselvi{at}pentester.es>
+// All rights reserved. Use of this source code is governed
+// by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestMeanVariance(t *testing.T) {
+	cases := []struct {
+		name         string
+		scores       []int
+		wantMean     float64
+		wantVariance float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", []int{5}, 5, 0},
+		{"spread", []int{2, 4, 4, 4, 5, 5, 7, 9}, 5, 4.571428571428571},
+	}
+	for _, c := range cases {
+		mean, variance := meanVariance(c.scores)
+		if math.Abs(mean-c.wantMean) > 1e-9 {
+			t.Errorf("%s: mean = %v, want %v", c.name, mean, c.wantMean)
+		}
+		if math.Abs(variance-c.wantVariance) > 1e-9 {
+			t.Errorf("%s: variance = %v, want %v", c.name, variance, c.wantVariance)
+		}
+	}
+}
+
+func TestWelchT(t *testing.T) {
+	if got := welchT(10, 1, 5, 10, 1, 5); got != 0 {
+		t.Errorf("equal means: welchT = %v, want 0", got)
+	}
+	if got := welchT(10, 0, 5, 0, 0, 5); !math.IsInf(got, 1) {
+		t.Errorf("zero variance, different means: welchT = %v, want +Inf", got)
+	}
+	if got := welchT(10, 1, 5, 0, 1, 5); got <= 0 {
+		t.Errorf("meanA > meanB: welchT = %v, want positive", got)
+	}
+}
+
+func TestPValueFromT(t *testing.T) {
+	if p := pValueFromT(0); math.Abs(p-1) > 1e-9 {
+		t.Errorf("pValueFromT(0) = %v, want 1", p)
+	}
+	if p := pValueFromT(100); p > 1e-9 {
+		t.Errorf("pValueFromT(100) = %v, want ~0", p)
+	}
+}
+
+func TestMajorityClass(t *testing.T) {
+	cases := []struct {
+		votes map[Classification]int
+		want  Classification
+	}{
+		{map[Classification]int{Right: 3, Wrong: 1}, Right},
+		{map[Classification]int{Right: 1, Wrong: 3}, Wrong},
+		{map[Classification]int{}, Wrong},
+		{map[Classification]int{Right: 2, Wrong: 2}, Wrong},
+		{map[Classification]int{Right: 2, Ambiguous: 2}, Ambiguous},
+	}
+	for _, c := range cases {
+		if got := majorityClass(c.votes); got != c.want {
+			t.Errorf("majorityClass(%v) = %v, want %v", c.votes, got, c.want)
+		}
+	}
+}
+
+// fixedOracle always returns the same score, for exercising Calibrate's
+// separability check directly.
+type fixedOracle struct {
+	scores map[string]int
+}
+
+func (o *fixedOracle) Probe(_ context.Context, payload string) (int, error) {
+	return o.scores[payload], nil
+}
+
+func TestCalibrateAndClassify(t *testing.T) {
+	oracle := &fixedOracle{scores: map[string]int{"right": 100, "wrong": 0}}
+	cal, err := Calibrate(context.Background(), oracle, "right", "wrong", 5, 0.05)
+	if err != nil {
+		t.Fatalf("Calibrate: %v", err)
+	}
+	if got := cal.Classify(100); got != Right {
+		t.Errorf("Classify(100) = %v, want Right", got)
+	}
+	if got := cal.Classify(0); got != Wrong {
+		t.Errorf("Classify(0) = %v, want Wrong", got)
+	}
+}
+
+func TestCalibrateInconclusive(t *testing.T) {
+	oracle := &fixedOracle{scores: map[string]int{"right": 50, "wrong": 50}}
+	if _, err := Calibrate(context.Background(), oracle, "right", "wrong", 5, 0.05); err == nil {
+		t.Fatal("Calibrate with identical right/wrong scores: want error, got nil")
+	}
+}